@@ -4,17 +4,28 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha512"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
+	// SelfTestPayload is the body signed by SelfTestSignature
+	SelfTestPayload = `{"self_test":"coinspaid"}`
+
 	// APIBaseLiveURL points to the live version of the API
 	APIBaseLiveURL = "https://app.coinspaid.com/api/v2/"
 
@@ -24,96 +35,1302 @@ const (
 
 // Client manages communication with the Coinspaid API.
 type Client struct {
+	mu         sync.RWMutex
 	apiKey     string
 	apiSecret  string
 	BaseURL    *url.URL
 	httpClient *http.Client
+
+	allowInsecureBaseURL bool
+	maxIdleConnsPerHost  int
+	maxConnsPerHost      int
+	signingObserver      func(path string, body []byte)
+	strictCurrencyCodes  bool
+	captureOnError       bool
+	withdrawalAllowlist  map[string]map[string]bool
+	wireDump             io.Writer
+	wireDumpMu           sync.Mutex
+	rateLimit            *RateLimit
+	serverTime           time.Time
+}
+
+// Option configures optional behaviour of the Client
+type Option func(*Client)
+
+// WithInsecureBaseURL allows a plain http base endpoint on a non-local host.
+// Requests carry the API key in a header, so only use it against test servers.
+func WithInsecureBaseURL() Option {
+	return func(client *Client) {
+		client.allowInsecureBaseURL = true
+	}
+}
+
+// WithHTTPClient makes the Client send requests through httpClient instead of
+// the default one. Transport options such as WithMaxConnsPerHost are then ignored.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithMaxIdleConnsPerHost sets how many idle connections to the API host are kept
+// for reuse. Go's default of 2 throttles concurrent withdrawals, a value matching
+// the number of concurrent callers (e.g. 16-32 for a payout worker pool) is reasonable.
+// It is ignored when WithHTTPClient is given or http.DefaultTransport isn't an *http.Transport.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(client *Client) {
+		client.maxIdleConnsPerHost = n
+	}
+}
+
+// WithMaxConnsPerHost caps the total number of connections to the API host.
+// Zero, the default, means no limit. It is ignored when WithHTTPClient is given or
+// http.DefaultTransport isn't an *http.Transport.
+func WithMaxConnsPerHost(n int) Option {
+	return func(client *Client) {
+		client.maxConnsPerHost = n
+	}
+}
+
+// WithSigningObserver calls observer with the request path and the exact body bytes
+// right before they are signed, to compare them against what a proxy or the server received.
+// The body slice is the one being signed and sent, observer must not modify it.
+func WithSigningObserver(observer func(path string, body []byte)) Option {
+	return func(client *Client) {
+		client.signingObserver = observer
+	}
+}
+
+// WithStrictCurrencyCodes sends currency codes exactly as given. By default the client
+// trims and uppercases them, so "btc" and " BTC" are both sent as "BTC".
+func WithStrictCurrencyCodes() Option {
+	return func(client *Client) {
+		client.strictCurrencyCodes = true
+	}
+}
+
+// WithCaptureOnError attaches a Diagnostic with the failed request and response to the
+// errors returned for non-2xx responses, e.g. to include in a support ticket. The API key
+// and signature are redacted, bodies are kept as sent. Off by default so payloads aren't retained.
+func WithCaptureOnError() Option {
+	return func(client *Client) {
+		client.captureOnError = true
+	}
+}
+
+// WithWithdrawalAllowlist restricts WithdrawCrypto to the listed destination addresses per
+// currency, e.g. {"BTC": {"3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt"}}. Withdrawals to any other address,
+// or in a currency missing from the map, fail with ErrAddressNotAllowed before anything is sent.
+// Hex (0x) addresses are compared case-insensitively, all others exactly. An empty map disables the check.
+func WithWithdrawalAllowlist(allowlist map[string][]string) Option {
+	return func(client *Client) {
+		if len(allowlist) == 0 {
+			client.withdrawalAllowlist = nil
+			return
+		}
+
+		client.withdrawalAllowlist = make(map[string]map[string]bool, len(allowlist))
+
+		for currency, addresses := range allowlist {
+			allowed := make(map[string]bool, len(addresses))
+
+			for _, address := range addresses {
+				allowed[normalizeAddress(address)] = true
+			}
+
+			client.withdrawalAllowlist[strings.ToUpper(strings.TrimSpace(currency))] = allowed
+		}
+	}
+}
+
+// WithWireDump writes a wire-level dump of every request and response to w, for debugging
+// integration issues. The X-Processing-Key and X-Processing-Signature values are redacted,
+// bodies are written in full.
+func WithWireDump(w io.Writer) Option {
+	return func(client *Client) {
+		client.wireDump = w
+	}
+}
+
+// RateLimit holds the request quota reported by the X-RateLimit-* response headers
+type RateLimit struct {
+	Limit     int
+	Remaining int
+
+	// Reset is when the quota is replenished, zero if the server didn't say. The header is
+	// read as unix seconds.
+	Reset time.Time
+}
+
+// Diagnostic is a redacted record of a failed call, attached to errors when WithCaptureOnError is given
+type Diagnostic struct {
+	Method         string
+	URL            string
+	RequestHeader  http.Header
+	RequestBody    []byte
+	StatusCode     int
+	ResponseHeader http.Header
+	ResponseBody   []byte
 }
 
 // ErrorResponse holds the error messages received from the API
 type ErrorResponse struct {
+	Response   *http.Response
+	Message    string      `json:"error"`
+	Code       string      `json:"code"`
+	Diagnostic *Diagnostic `json:"-"`
+}
+
+func (r *ErrorResponse) Error() string {
+	return fmt.Sprintf("%v %v - %d %v %v",
+		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Message, r.Code)
+}
+
+var (
+	// ErrAuth is wrapped by both ErrUnauthorized and ErrForbidden
+	ErrAuth = errors.New("coinspaid: authentication failed")
+
+	// ErrUnauthorized matches a 401 ErrorResponse, the credentials are missing or invalid
+	ErrUnauthorized = fmt.Errorf("%w: unauthorized", ErrAuth)
+
+	// ErrForbidden matches a 403 ErrorResponse, the credentials are valid but not allowed to do this
+	ErrForbidden = fmt.Errorf("%w: forbidden", ErrAuth)
+)
+
+// Unwrap returns ErrUnauthorized or ErrForbidden for auth failures, nil otherwise
+func (r *ErrorResponse) Unwrap() error {
+	switch r.Response.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// ValidationErrorResponse holds the error messages received from the API for validation errors
+type ValidationErrorResponse struct {
+	Response *http.Response
+
+	// Errors holds the first message for each invalid field
+	Errors map[string]string `json:"errors"`
+
+	// Messages holds every message for each invalid field
+	Messages map[string][]string `json:"-"`
+
+	Diagnostic *Diagnostic `json:"-"`
+}
+
+// UnmarshalJSON accepts both a single message and a list of messages per field. Any other
+// value, e.g. a nested object, is kept as its raw JSON text so the rest still decodes.
+func (r *ValidationErrorResponse) UnmarshalJSON(data []byte) error {
+	var temp struct {
+		Errors map[string]json.RawMessage `json:"errors"`
+	}
+
+	err := json.Unmarshal(data, &temp)
+
+	if err != nil {
+		return err
+	}
+
+	if temp.Errors == nil {
+		return nil
+	}
+
+	r.Errors = make(map[string]string, len(temp.Errors))
+	r.Messages = make(map[string][]string, len(temp.Errors))
+
+	for field, raw := range temp.Errors {
+		var messages []string
+
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			var message string
+
+			if err := json.Unmarshal(raw, &message); err != nil {
+				message = string(raw)
+			}
+
+			messages = []string{message}
+		}
+
+		if len(messages) > 0 {
+			r.Errors[field] = messages[0]
+		}
+
+		r.Messages[field] = messages
+	}
+
+	return nil
+}
+
+// First returns the first message for field, or an empty string if it's valid
+func (r *ValidationErrorResponse) First(field string) string {
+	return r.Errors[field]
+}
+
+// All returns every message for field
+func (r *ValidationErrorResponse) All(field string) []string {
+	return r.Messages[field]
+}
+
+func (r *ValidationErrorResponse) Error() string {
+	return fmt.Sprintf("%v %v - %d %v",
+		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Errors)
+}
+
+// ErrInputRequired is returned when a method whose input can't be nil is given a nil input
+var ErrInputRequired = errors.New("coinspaid: input is required")
+
+// ErrAddressNotAllowed is returned when a withdrawal destination isn't on the allowlist given to WithWithdrawalAllowlist
+var ErrAddressNotAllowed = errors.New("coinspaid: address is not on the withdrawal allowlist")
+
+// ErrTagRequired matches, via errors.Is, a ValidationErrorResponse rejecting a withdrawal
+// that lacks the tag or memo its currency needs
+var ErrTagRequired = errors.New("coinspaid: tag is required for this currency")
+
+// Is reports whether the validation errors match target
+func (r *ValidationErrorResponse) Is(target error) bool {
+	if target == ErrTagRequired {
+		for _, message := range r.All("tag") {
+			if strings.Contains(strings.ToLower(message), "required") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ErrMaintenance is wrapped by MaintenanceError, test for it with errors.Is
+var ErrMaintenance = errors.New("coinspaid: service under maintenance")
+
+// MaintenanceError is returned when the API answers 503 because of scheduled maintenance
+type MaintenanceError struct {
 	Response *http.Response
-	Message  string `json:"error"`
-	Code     string `json:"code"`
+	Message  string
+
+	// RetryAfter is the wait suggested by the Retry-After header, zero when absent
+	RetryAfter time.Duration
+
+	Diagnostic *Diagnostic
+}
+
+func (r *MaintenanceError) Error() string {
+	return fmt.Sprintf("%v %v - %d %v (retry after %v)",
+		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Message, r.RetryAfter)
+}
+
+// Unwrap returns ErrMaintenance
+func (r *MaintenanceError) Unwrap() error {
+	return ErrMaintenance
+}
+
+// NewClient returns a new instance of the Coinspaid client with the provided options.
+// The base endpoint must use https unless it points to localhost or WithInsecureBaseURL is given.
+func NewClient(apiKey string, apiSecret string, baseEndpoint string, options ...Option) (*Client, error) {
+	if apiKey == "" || apiSecret == "" || baseEndpoint == "" {
+		return nil, errors.New("apiKey, apiSecret and baseEndpoint are required to create a Client")
+	}
+
+	baseURL, err := url.Parse(baseEndpoint)
+
+	if err != nil {
+		return nil, errors.New("can't parse base endpoint")
+	}
+
+	client := &Client{
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		BaseURL:   baseURL,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	if client.httpClient == nil {
+		client.httpClient = &http.Client{
+			Timeout:   time.Second * 10,
+			Transport: client.newTransport(),
+		}
+	}
+
+	if baseURL.Scheme != "https" && !client.allowInsecureBaseURL && !isLocalHost(baseURL.Hostname()) {
+		return nil, fmt.Errorf("base endpoint %q must use https", baseEndpoint)
+	}
+
+	return client, nil
+}
+
+// newTransport applies the connection pool options to a copy of http.DefaultTransport. When
+// http.DefaultTransport has been wrapped, e.g. by instrumentation, it returns nil so the
+// wrapper is used as is and the pool options are ignored.
+func (client *Client) newTransport() http.RoundTripper {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+
+	if !ok {
+		return nil
+	}
+
+	transport = transport.Clone()
+
+	if client.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = client.maxIdleConnsPerHost
+	}
+
+	transport.MaxConnsPerHost = client.maxConnsPerHost
+
+	return transport
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// SetCredentials replaces the API key and secret used to sign subsequent requests.
+// It is safe to call while requests are in flight, each request is signed with either
+// the old or the new pair, never a mix of both.
+func (client *Client) SetCredentials(apiKey string, apiSecret string) error {
+	if apiKey == "" || apiSecret == "" {
+		return errors.New("apiKey and apiSecret are required to set credentials")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.apiKey = apiKey
+	client.apiSecret = apiSecret
+
+	return nil
+}
+
+func (client *Client) credentials() (apiKey string, apiSecret string) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	return client.apiKey, client.apiSecret
+}
+
+// SelfTestSignature returns the signature of SelfTestPayload under the current secret.
+// Comparing it to a value computed offline from the expected secret, e.g. in a deploy smoke
+// test, catches a mis-copied or corrupted secret before any real call is made.
+func (client *Client) SelfTestSignature() (string, error) {
+	_, apiSecret := client.credentials()
+
+	if strings.TrimSpace(apiSecret) == "" {
+		return "", errors.New("apiSecret is empty")
+	}
+
+	if strings.TrimSpace(apiSecret) != apiSecret {
+		return "", errors.New("apiSecret has leading or trailing whitespace")
+	}
+
+	return createSignedRequestHeader(apiSecret, []byte(SelfTestPayload))
+}
+
+// LastRateLimit returns the quota reported with the most recent response.
+// The boolean is false until a response carrying both X-RateLimit-Limit and
+// X-RateLimit-Remaining arrives; responses missing either leave the last value in place.
+func (client *Client) LastRateLimit() (RateLimit, bool) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.rateLimit == nil {
+		return RateLimit{}, false
+	}
+
+	return *client.rateLimit, true
+}
+
+func (client *Client) recordRateLimit(res *http.Response) {
+	limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit"))
+
+	if err != nil {
+		return
+	}
+
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+
+	if err != nil {
+		return
+	}
+
+	rateLimit := &RateLimit{Limit: limit, Remaining: remaining}
+
+	if reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		rateLimit.Reset = time.Unix(reset, 0)
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.rateLimit = rateLimit
+}
+
+// currencyCode normalizes an ISO currency code unless WithStrictCurrencyCodes was given
+func (client *Client) currencyCode(code string) string {
+	if client.strictCurrencyCodes {
+		return code
+	}
+
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// normalizeAddress prepares an address for allowlist comparison. Only hex addresses are
+// case-insensitive, base58 and most other encodings are not and are compared as given.
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+
+	if strings.HasPrefix(address, "0x") || strings.HasPrefix(address, "0X") {
+		return strings.ToLower(address)
+	}
+
+	return address
+}
+
+// LastServerTime returns the Date header of the most recent response, for comparing the
+// server clock to the local one. The boolean is false until a response with a valid Date arrives.
+func (client *Client) LastServerTime() (time.Time, bool) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	return client.serverTime, !client.serverTime.IsZero()
+}
+
+func (client *Client) recordServerTime(res *http.Response) {
+	serverTime, err := http.ParseTime(res.Header.Get("Date"))
+
+	if err != nil {
+		return
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.serverTime = serverTime
+}
+
+// newRequest builds a signed POST request for the given API path and JSON body.
+// The body is sent exactly as signed, so it must come from json.Marshal: json.Encoder
+// appends a trailing newline and its output would have to be trimmed before signing.
+func (client *Client) newRequest(path string, body []byte) (*http.Request, error) {
+	relativeURL := &url.URL{Path: path}
+	url := client.BaseURL.ResolveReference(relativeURL)
+
+	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, apiSecret := client.credentials()
+
+	if client.signingObserver != nil {
+		client.signingObserver(req.URL.Path, body)
+	}
+
+	signedBody, err := createSignedRequestHeader(apiSecret, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// X-Processing-Key and X-Processing-Signature are already canonical, Set sends them as spelled
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Processing-Key", apiKey)
+	req.Header.Set("X-Processing-Signature", signedBody)
+
+	return req, nil
+}
+
+func (client *Client) doRequest(req *http.Request, v interface{}) (*http.Response, error) {
+	if client.wireDump != nil {
+		client.dumpRequest(req)
+	}
+
+	res, err := client.httpClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if client.wireDump != nil {
+		client.dumpResponse(res)
+	}
+
+	client.recordRateLimit(res)
+	client.recordServerTime(res)
+
+	var responseBody []byte
+
+	if client.captureOnError && res.StatusCode > 299 {
+		responseBody, _ = ioutil.ReadAll(res.Body)
+		res.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+	}
+
+	err = checkResponse(res)
+
+	if err != nil {
+		if client.captureOnError {
+			attachDiagnostic(err, newDiagnostic(req, res, responseBody))
+		}
+
+		return nil, err
+	}
+
+	err = json.NewDecoder(res.Body).Decode(v)
+
+	return res, err
 }
 
-func (r *ErrorResponse) Error() string {
-	return fmt.Sprintf("%v %v - %d %v %v",
-		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Message, r.Code)
+func (client *Client) dumpRequest(req *http.Request) {
+	redacted := req.Clone(req.Context())
+
+	for _, header := range []string{"X-Processing-Key", "X-Processing-Signature"} {
+		if redacted.Header.Get(header) != "" {
+			redacted.Header.Set(header, "[REDACTED]")
+		}
+	}
+
+	if req.GetBody != nil {
+		redacted.Body, _ = req.GetBody()
+	}
+
+	dump, err := httputil.DumpRequestOut(redacted, redacted.Body != nil)
+
+	if err != nil {
+		return
+	}
+
+	client.writeDump(dump)
+}
+
+func (client *Client) dumpResponse(res *http.Response) {
+	dump, err := httputil.DumpResponse(res, true)
+
+	if err != nil {
+		return
+	}
+
+	client.writeDump(dump)
+}
+
+func (client *Client) writeDump(dump []byte) {
+	client.wireDumpMu.Lock()
+	defer client.wireDumpMu.Unlock()
+
+	client.wireDump.Write(dump)
+	client.wireDump.Write([]byte("\n\n"))
+}
+
+func newDiagnostic(req *http.Request, res *http.Response, responseBody []byte) *Diagnostic {
+	diagnostic := &Diagnostic{
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeader:  req.Header.Clone(),
+		StatusCode:     res.StatusCode,
+		ResponseHeader: res.Header.Clone(),
+		ResponseBody:   responseBody,
+	}
+
+	for _, header := range []string{"X-Processing-Key", "X-Processing-Signature"} {
+		if diagnostic.RequestHeader.Get(header) != "" {
+			diagnostic.RequestHeader.Set(header, "[REDACTED]")
+		}
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			diagnostic.RequestBody, _ = ioutil.ReadAll(body)
+		}
+	}
+
+	return diagnostic
+}
+
+func attachDiagnostic(err error, diagnostic *Diagnostic) {
+	switch e := err.(type) {
+	case *ErrorResponse:
+		e.Diagnostic = diagnostic
+	case *ValidationErrorResponse:
+		e.Diagnostic = diagnostic
+	case *MaintenanceError:
+		e.Diagnostic = diagnostic
+	}
+}
+
+// Address holds the data returned from the API
+type Address struct {
+	ID       int    `json:"id"`
+	Currency string `json:"currency"`
+	// ConvertTo is the settlement currency the server applied to the address. It may differ
+	// from TakeAddressInput.ConvertTo when the server normalizes or defaults it.
+	ConvertTo string `json:"convert_to"`
+	Address   string `json:"address"`
+	Tag       string `json:"tag"`
+	ForeignID string `json:"foreign_id"`
+}
+
+// UnmarshalJSON parses the request from server in the expected format
+func (a *Address) UnmarshalJSON(data []byte) error {
+	type Alias Address
+
+	var temp struct {
+		Data Alias `json:"data"`
+	}
+
+	err := json.Unmarshal(data, &temp)
+
+	if err != nil {
+		return err
+	}
+
+	*a = Address(temp.Data)
+	return nil
+}
+
+// MakeForeignID joins parts into a foreign id using the colon separated style
+// of the API docs, e.g. MakeForeignID("user-id", "2048") returns "user-id:2048"
+func MakeForeignID(parts ...string) string {
+	return strings.Join(parts, ":")
+}
+
+// TakeAddressInput specifies the parameters the TakeAddress method accepts.
+type TakeAddressInput struct {
+	// Your info for this address, will returned as reference in Address responses, example: user-id:2048
+	ForeignID string `json:"foreign_id"`
+
+	// ISO of currency to receive funds in, example: BTC
+	Currency string `json:"currency"`
+
+	// ISO of currency to convert deposits to, example: EUR. Omit to keep funds in Currency.
+	ConvertTo string `json:"convert_to,omitempty"`
+}
+
+// TakeAddress Returns the address for depositing crypto.
+// Taking an address is idempotent per foreign id and currency, and the API answers the same way
+// whether the address was just created or already existed, so callers can't tell the two apart.
+func (client *Client) TakeAddress(input *TakeAddressInput) (*Address, error) {
+
+	if input == nil {
+		return nil, ErrInputRequired
+	}
+
+	normalized := *input
+	normalized.Currency = client.currencyCode(input.Currency)
+	normalized.ConvertTo = client.currencyCode(input.ConvertTo)
+
+	j, err := json.Marshal(&normalized)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := client.newRequest("addresses/take", j)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var address Address
+
+	_, err = client.doRequest(req, &address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &address, nil
+}
+
+// ID holds a transaction id exactly as sent by the API. Numeric ids are kept as their
+// decimal text, so ids beyond float64 precision don't lose digits.
+type ID string
+
+// UnmarshalJSON accepts both numeric and string ids, null leaves the id empty
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+
+		*id = ID(value)
+		return nil
+	}
+
+	*id = ID(data)
+	return nil
+}
+
+// QuoteID holds the id of a futures quote along with whether the API sent it as a JSON
+// number, so ConfirmFuturesTransaction can send it back exactly as received
+type QuoteID struct {
+	Value string
+
+	// Numeric is true when the id was a JSON number, it is then written unquoted
+	Numeric bool
+}
+
+// String returns the id as text
+func (id QuoteID) String() string {
+	return id.Value
+}
+
+// UnmarshalJSON accepts both numeric and string ids, null leaves the id empty
+func (id *QuoteID) UnmarshalJSON(data []byte) error {
+	var value ID
+
+	if err := value.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	*id = QuoteID{
+		Value:   string(value),
+		Numeric: value != "" && data[0] != '"',
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the id as a JSON number if it was received as one, and quoted otherwise
+func (id QuoteID) MarshalJSON() ([]byte, error) {
+	if !id.Numeric {
+		return json.Marshal(id.Value)
+	}
+
+	if !isJSONNumber(id.Value) {
+		return nil, fmt.Errorf("coinspaid: quote id %q is not a JSON number", id.Value)
+	}
+
+	return []byte(id.Value), nil
+}
+
+func isJSONNumber(value string) bool {
+	if value == "" || (value[0] != '-' && (value[0] < '0' || value[0] > '9')) {
+		return false
+	}
+
+	return json.Valid([]byte(value))
+}
+
+var (
+	// timeLayouts lists the string timestamp formats returned by the API, tried in order
+	timeLayouts   = []string{time.RFC3339, "2006-01-02 15:04:05"}
+	timeLayoutsMu sync.RWMutex
+)
+
+// RegisterTimeLayout adds a time.Parse layout that Time tries after the known ones, for an
+// endpoint using a timestamp style the package doesn't know yet. It affects every Client,
+// since Time is decoded without access to one; registering a known layout is a no-op.
+func RegisterTimeLayout(layout string) {
+	timeLayoutsMu.Lock()
+	defer timeLayoutsMu.Unlock()
+
+	for _, known := range timeLayouts {
+		if known == layout {
+			return
+		}
+	}
+
+	timeLayouts = append(timeLayouts, layout)
+}
+
+// Time decodes the timestamp styles used across the API: unix seconds, optionally with a
+// fractional part, RFC3339, "2006-01-02 15:04:05" (UTC) and any layout added with
+// RegisterTimeLayout, either quoted or not
+type Time struct {
+	time.Time
+}
+
+// UnmarshalJSON parses the timestamp from server in any of the known formats
+func (t *Time) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+
+	if value == "" || value == "null" {
+		return nil
+	}
+
+	if unix, ok := parseUnixTime(value); ok {
+		t.Time = unix
+		return nil
+	}
+
+	timeLayoutsMu.RLock()
+	defer timeLayoutsMu.RUnlock()
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't parse time %q", value)
+}
+
+// parseUnixTime parses unix seconds such as 1589452200 or 1589452200.5 without going through
+// float64, which would round the fraction
+func parseUnixTime(value string) (time.Time, bool) {
+	whole, fraction := value, ""
+
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		whole, fraction = value[:i], value[i+1:]
+
+		if fraction == "" || len(fraction) > 9 || strings.Trim(fraction, "0123456789") != "" {
+			return time.Time{}, false
+		}
+	}
+
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var nanoseconds int64
+
+	if fraction != "" {
+		nanoseconds, _ = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+	}
+
+	return time.Unix(seconds, nanoseconds).UTC(), true
+}
+
+// Amount is a quantity of funds in whole units of a currency. It is sent as a plain
+// decimal number, so small amounts like 0.0000005 never go out in exponent form.
+type Amount float64
+
+// MarshalJSON writes the amount in the shortest decimal form that reads back as the same float64
+func (a Amount) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(a)) || math.IsInf(float64(a), 0) {
+		return nil, fmt.Errorf("coinspaid: unsupported amount %v", float64(a))
+	}
+
+	return []byte(strconv.FormatFloat(float64(a), 'f', -1, 64)), nil
+}
+
+// WithdrawCryptoInput specifies the parameters the WithdrawCrypto method accepts.
+type WithdrawCryptoInput struct {
+	// Unique foreign ID in your system, example: "122929"
+	ForeignID string `json:"foreign_id"`
+
+	// Amount of funds to withdraw in whole units of Currency (not satoshis or other
+	// minor units), example: 0.01 withdraws 0.01 BTC
+	Amount Amount `json:"amount"`
+
+	// ISO of currency to receive funds in, example: BTC
+	Currency string `json:"currency"`
+
+	// Cryptocurrency address where you want to send funds.
+	Address string `json:"address"`
+
+	// Tag (if it's Ripple or BNB) or memo (if it's Bitshares or EOS)
+	Tag string `json:"tag"`
+}
+
+// UnmarshalJSON parses the request from server in the expected format
+func (a *WithdrawCryptoPayload) UnmarshalJSON(data []byte) error {
+	type Alias WithdrawCryptoPayload
+
+	var temp struct {
+		Data Alias `json:"data"`
+	}
+
+	err := json.Unmarshal(data, &temp)
+
+	if err != nil {
+		return err
+	}
+
+	*a = WithdrawCryptoPayload(temp.Data)
+	return nil
+}
+
+// WithdrawCryptoPayload holds the data returned from the API
+type WithdrawCryptoPayload struct {
+	ID               ID     `json:"id"`
+	ForeignID        string `json:"foreign_id"`
+	Type             string `json:"type"`
+	Status           string `json:"status"`
+	Amount           string `json:"amount"`
+	SenderCurrency   string `json:"sender_currency"`
+	SenderAmount     string `json:"sender_amount"`
+	ReceiverCurrency string `json:"receiver_currency"`
+	ReceiverAmount   string `json:"receiver_amount"`
+
+	// Network the withdrawal was sent on for currencies spanning several chains, example: ERC20.
+	// Empty for single-chain currencies.
+	Network string `json:"network"`
+}
+
+// BuildWithdrawCryptoRequest returns the signed request WithdrawCrypto would send, without sending it,
+// along with the exact body bytes that were signed. The request body reads the same bytes, which
+// must not be modified.
+func (client *Client) BuildWithdrawCryptoRequest(input *WithdrawCryptoInput) (*http.Request, []byte, error) {
+
+	if input == nil {
+		return nil, nil, ErrInputRequired
+	}
+
+	if input.Amount <= 0 {
+		return nil, nil, errors.New("amount must be greater than zero")
+	}
+
+	normalized := *input
+	normalized.Currency = client.currencyCode(input.Currency)
+
+	if client.withdrawalAllowlist != nil {
+		allowed := client.withdrawalAllowlist[strings.ToUpper(strings.TrimSpace(normalized.Currency))]
+
+		if !allowed[normalizeAddress(normalized.Address)] {
+			return nil, nil, fmt.Errorf("%w: %s %s", ErrAddressNotAllowed, normalized.Currency, normalized.Address)
+		}
+	}
+
+	j, err := json.Marshal(&normalized)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := client.newRequest("withdrawal/crypto", j)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, j, nil
+}
+
+// WithdrawCrypto Withdraw crypto to any specified address.
+func (client *Client) WithdrawCrypto(input *WithdrawCryptoInput) (*WithdrawCryptoPayload, error) {
+
+	req, _, err := client.BuildWithdrawCryptoRequest(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var withdrawCryptoPayload WithdrawCryptoPayload
+
+	_, err = client.doRequest(req, &withdrawCryptoPayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &withdrawCryptoPayload, nil
+}
+
+// AccountBalance holds the balance of one of the merchant accounts returned from the API
+type AccountBalance struct {
+	// ISO of the account currency, example: BTC
+	Currency string `json:"currency"`
+
+	// Type of the currency, crypto or fiat
+	Type string `json:"type"`
+
+	// Balance of the account, example: "0.05000000"
+	Balance string `json:"balance"`
+}
+
+// GetBalances Returns the balances of all the merchant accounts
+func (client *Client) GetBalances() ([]AccountBalance, error) {
+
+	req, err := client.newRequest("accounts/list", []byte("{}"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []AccountBalance `json:"data"`
+	}
+
+	_, err = client.doRequest(req, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+// Currency holds the metadata of a currency supported by the API
+type Currency struct {
+	ID int `json:"id"`
+
+	// Type of the currency, crypto or fiat
+	Type string `json:"type"`
+
+	// ISO of the currency, example: BTC
+	Currency string `json:"currency"`
+
+	// Minimum amount accepted for deposits, example: "0.00020000"
+	MinimumAmount string `json:"minimum_amount"`
+
+	// Fee charged on deposits, in percent, example: "0.008000"
+	DepositFeePercent string `json:"deposit_fee_percent"`
+
+	// Fixed fee charged on withdrawals, in units of the currency, example: "0.00040000"
+	WithdrawalFee string `json:"withdrawal_fee"`
+
+	// Number of decimal places amounts in this currency support, example: 8
+	Precision int `json:"precision"`
+}
+
+// ListCurrencies Returns the currencies supported by the API
+func (client *Client) ListCurrencies() ([]Currency, error) {
+
+	req, err := client.newRequest("currencies/list", []byte("{}"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []Currency `json:"data"`
+	}
+
+	_, err = client.doRequest(req, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
+}
+
+// CurrencyPairsInput specifies the parameters the ListCurrencyPairs method accepts, both filters are optional.
+type CurrencyPairsInput struct {
+	// ISO of the currency to convert from, example: BTC
+	CurrencyFrom string `json:"currency_from,omitempty"`
+
+	// ISO of the currency to convert to, example: EUR
+	CurrencyTo string `json:"currency_to,omitempty"`
+}
+
+// CurrencyPairCurrency holds one side of a currency pair returned from the API
+type CurrencyPairCurrency struct {
+	// ISO of the currency, example: BTC
+	Currency string `json:"currency"`
+
+	// Type of the currency, crypto or fiat
+	Type string `json:"type"`
+
+	// Minimum amount that can be exchanged, only set on the currency to convert from
+	MinAmount string `json:"min_amount,omitempty"`
+
+	// Minimum deposit amount for addresses converting to the other currency, only set on the currency to convert from
+	MinAmountDepositWithExchange string `json:"min_amount_deposit_with_exchange,omitempty"`
+}
+
+// CurrencyPair holds a conversion supported by the API
+type CurrencyPair struct {
+	CurrencyFrom CurrencyPairCurrency `json:"currency_from"`
+	CurrencyTo   CurrencyPairCurrency `json:"currency_to"`
+
+	// RateFrom units of CurrencyFrom convert to RateTo units of CurrencyTo
+	RateFrom string `json:"rate_from"`
+	RateTo   string `json:"rate_to"`
+}
+
+// ListCurrencyPairs Returns the currency pairs that can be exchanged, input may be nil to list all of them
+func (client *Client) ListCurrencyPairs(input *CurrencyPairsInput) ([]CurrencyPair, error) {
+
+	normalized := CurrencyPairsInput{}
+
+	if input != nil {
+		normalized.CurrencyFrom = client.currencyCode(input.CurrencyFrom)
+		normalized.CurrencyTo = client.currencyCode(input.CurrencyTo)
+	}
+
+	j, err := json.Marshal(&normalized)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := client.newRequest("currencies/pairs", j)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []CurrencyPair `json:"data"`
+	}
+
+	_, err = client.doRequest(req, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
 }
 
-// ValidationErrorResponse holds the error messages received from the API for validation errors
-type ValidationErrorResponse struct {
-	Response *http.Response
-	Errors   map[string]string `json:"errors"`
+// CalculateExchangeInput specifies the parameters the CalculateExchange method accepts.
+// Exactly one of SenderAmount and ReceiverAmount must be set.
+type CalculateExchangeInput struct {
+	// ISO of currency to exchange from, example: BTC
+	SenderCurrency string `json:"sender_currency"`
+
+	// ISO of currency to exchange to, example: EUR
+	ReceiverCurrency string `json:"receiver_currency"`
+
+	// Amount of SenderCurrency to exchange in whole units, example: 0.01
+	SenderAmount Amount `json:"sender_amount,omitempty"`
+
+	// Amount of ReceiverCurrency to receive in whole units, example: 100
+	ReceiverAmount Amount `json:"receiver_amount,omitempty"`
 }
 
-func (r *ValidationErrorResponse) Error() string {
-	return fmt.Sprintf("%v %v - %d %v",
-		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Errors)
+// ExchangeCalculation holds the quote returned from the API
+type ExchangeCalculation struct {
+	SenderAmount     string `json:"sender_amount"`
+	SenderCurrency   string `json:"sender_currency"`
+	ReceiverAmount   string `json:"receiver_amount"`
+	ReceiverCurrency string `json:"receiver_currency"`
+	FeeAmount        string `json:"fee_amount"`
+	FeeCurrency      string `json:"fee_currency"`
+
+	// Price of one unit of SenderCurrency in ReceiverCurrency, pass it to ExchangeFixed to use this quote
+	Price string `json:"price"`
+
+	// TsFixed is when the price was fixed and TsRelease when it stops being honoured
+	TsFixed   Time `json:"ts_fixed"`
+	TsRelease Time `json:"ts_release"`
+
+	// FixPeriod is how long the price is honoured, in seconds
+	FixPeriod int `json:"fix_period"`
 }
 
-// NewClient returns a new instance of the Coinspaid client with the provided options
-func NewClient(apiKey string, apiSecret string, baseEndpoint string) (*Client, error) {
-	if apiKey == "" || apiSecret == "" || baseEndpoint == "" {
-		return nil, errors.New("apiKey, apiSecret and baseEndpoint are required to create a Client")
-	}
+// UnmarshalJSON parses the request from server in the expected format
+func (a *ExchangeCalculation) UnmarshalJSON(data []byte) error {
+	type Alias ExchangeCalculation
 
-	httpClient := &http.Client{
-		Timeout: time.Second * 10,
+	var temp struct {
+		Data Alias `json:"data"`
 	}
 
-	baseURL, err := url.Parse(baseEndpoint)
+	err := json.Unmarshal(data, &temp)
 
 	if err != nil {
-		return nil, errors.New("can't parse base endpoint")
+		return err
 	}
 
-	return &Client{
-		apiKey:     apiKey,
-		apiSecret:  apiSecret,
-		httpClient: httpClient,
-		BaseURL:    baseURL,
-	}, nil
+	*a = ExchangeCalculation(temp.Data)
+	return nil
 }
 
-func (client *Client) doRequest(req *http.Request, v interface{}) (*http.Response, error) {
-	httpClient := &http.Client{
-		Timeout: time.Second * 10,
+func validateExchangeAmounts(senderAmount Amount, receiverAmount Amount) error {
+	if senderAmount < 0 || receiverAmount < 0 {
+		return errors.New("exchange amounts can't be negative")
 	}
 
-	res, err := httpClient.Do(req)
+	if (senderAmount == 0) == (receiverAmount == 0) {
+		return errors.New("exactly one of sender_amount and receiver_amount is required")
+	}
 
-	if err != nil {
+	return nil
+}
+
+// CalculateExchange Returns a quote for exchanging between two currencies without executing it
+func (client *Client) CalculateExchange(input *CalculateExchangeInput) (*ExchangeCalculation, error) {
+
+	if input == nil {
+		return nil, ErrInputRequired
+	}
+
+	if err := validateExchangeAmounts(input.SenderAmount, input.ReceiverAmount); err != nil {
 		return nil, err
 	}
 
-	defer res.Body.Close()
+	normalized := *input
+	normalized.SenderCurrency = client.currencyCode(input.SenderCurrency)
+	normalized.ReceiverCurrency = client.currencyCode(input.ReceiverCurrency)
 
-	err = checkResponse(res)
+	j, err := json.Marshal(&normalized)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.NewDecoder(res.Body).Decode(v)
+	req, err := client.newRequest("exchange/calculate", j)
 
-	return res, err
+	if err != nil {
+		return nil, err
+	}
+
+	var exchangeCalculation ExchangeCalculation
+
+	_, err = client.doRequest(req, &exchangeCalculation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &exchangeCalculation, nil
 }
 
-// Address holds the data returned from the API
-type Address struct {
-	ID        int    `json:"id"`
-	Currency  string `json:"currency"`
-	ConvertTo string `json:"convert_to"`
-	Address   string `json:"address"`
-	Tag       string `json:"tag"`
+// ExchangeFixedInput specifies the parameters the ExchangeFixed method accepts.
+// Exactly one of SenderAmount and ReceiverAmount must be set.
+type ExchangeFixedInput struct {
+	// Unique foreign ID in your system, example: "122929"
 	ForeignID string `json:"foreign_id"`
+
+	// ISO of currency to exchange from, example: BTC
+	SenderCurrency string `json:"sender_currency"`
+
+	// ISO of currency to exchange to, example: EUR
+	ReceiverCurrency string `json:"receiver_currency"`
+
+	// Amount of SenderCurrency to exchange in whole units, example: 0.01
+	SenderAmount Amount `json:"sender_amount,omitempty"`
+
+	// Amount of ReceiverCurrency to receive in whole units, example: 100
+	ReceiverAmount Amount `json:"receiver_amount,omitempty"`
+
+	// Price returned by CalculateExchange, exactly as received, example: "8905.40000000"
+	Price string `json:"price"`
+}
+
+// ExchangePayload holds the exchange transaction returned from the API
+type ExchangePayload struct {
+	ID               ID     `json:"id"`
+	ForeignID        string `json:"foreign_id"`
+	Type             string `json:"type"`
+	Status           string `json:"status"`
+	SenderAmount     string `json:"sender_amount"`
+	SenderCurrency   string `json:"sender_currency"`
+	ReceiverAmount   string `json:"receiver_amount"`
+	ReceiverCurrency string `json:"receiver_currency"`
+	FeeAmount        string `json:"fee_amount"`
+	FeeCurrency      string `json:"fee_currency"`
+	Price            string `json:"price"`
 }
 
 // UnmarshalJSON parses the request from server in the expected format
-func (a *Address) UnmarshalJSON(data []byte) error {
-	type Alias Address
+func (a *ExchangePayload) UnmarshalJSON(data []byte) error {
+	type Alias ExchangePayload
 
 	var temp struct {
 		Data Alias `json:"data"`
@@ -125,86 +1342,179 @@ func (a *Address) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	*a = Address(temp.Data)
+	*a = ExchangePayload(temp.Data)
 	return nil
 }
 
-// TakeAddressInput specifies the parameters the TakeAddress method accepts.
-type TakeAddressInput struct {
-	// Your info for this address, will returned as reference in Address responses, example: user-id:2048
+// ExchangeFixed Exchanges between two currencies at the price of a prior CalculateExchange quote.
+// The API rejects the exchange once the quote's TsRelease has passed.
+func (client *Client) ExchangeFixed(input *ExchangeFixedInput) (*ExchangePayload, error) {
+
+	if input == nil {
+		return nil, ErrInputRequired
+	}
+
+	if err := validateExchangeAmounts(input.SenderAmount, input.ReceiverAmount); err != nil {
+		return nil, err
+	}
+
+	if input.Price == "" {
+		return nil, errors.New("price is required for a fixed exchange")
+	}
+
+	normalized := *input
+	normalized.SenderCurrency = client.currencyCode(input.SenderCurrency)
+	normalized.ReceiverCurrency = client.currencyCode(input.ReceiverCurrency)
+
+	j, err := json.Marshal(&normalized)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := client.newRequest("exchange/fixed", j)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var exchangePayload ExchangePayload
+
+	_, err = client.doRequest(req, &exchangePayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &exchangePayload, nil
+}
+
+// ExchangeNowInput specifies the parameters the ExchangeNow method accepts.
+// Exactly one of SenderAmount and ReceiverAmount must be set.
+type ExchangeNowInput struct {
+	// Unique foreign ID in your system, example: "122929"
 	ForeignID string `json:"foreign_id"`
 
-	// ISO of currency to receive funds in, example: BTC
-	Currency string `json:"currency"`
+	// ISO of currency to exchange from, example: BTC
+	SenderCurrency string `json:"sender_currency"`
+
+	// ISO of currency to exchange to, example: EUR
+	ReceiverCurrency string `json:"receiver_currency"`
+
+	// Amount of SenderCurrency to exchange in whole units, example: 0.01
+	SenderAmount Amount `json:"sender_amount,omitempty"`
+
+	// Amount of ReceiverCurrency to receive in whole units, example: 100
+	ReceiverAmount Amount `json:"receiver_amount,omitempty"`
 }
 
-// TakeAddress Returns the address for depositing crypto
-func (client *Client) TakeAddress(input *TakeAddressInput) (*Address, error) {
+// ExchangeNow Exchanges between two currencies immediately at the current market price
+func (client *Client) ExchangeNow(input *ExchangeNowInput) (*ExchangePayload, error) {
 
-	relativeURL := &url.URL{Path: "addresses/take"}
-	url := client.BaseURL.ResolveReference(relativeURL)
+	if input == nil {
+		return nil, ErrInputRequired
+	}
 
-	j, err := json.Marshal(input)
+	if err := validateExchangeAmounts(input.SenderAmount, input.ReceiverAmount); err != nil {
+		return nil, err
+	}
+
+	normalized := *input
+	normalized.SenderCurrency = client.currencyCode(input.SenderCurrency)
+	normalized.ReceiverCurrency = client.currencyCode(input.ReceiverCurrency)
+
+	j, err := json.Marshal(&normalized)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(j))
+	req, err := client.newRequest("exchange/now", j)
 
 	if err != nil {
 		return nil, err
 	}
 
-	signedBody, err := client.createSignedRequestHeader(j)
+	var exchangePayload ExchangePayload
+
+	_, err = client.doRequest(req, &exchangePayload)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Processing-Key", client.apiKey)
-	req.Header.Set("X-Processing-Signature", signedBody)
+	return &exchangePayload, nil
+}
 
-	var address Address
+// FuturesRate holds a guaranteed exchange rate offered for future deposits
+type FuturesRate struct {
+	// ISO of the deposited currency, example: BTC
+	CurrencyFrom string `json:"currency_from"`
 
-	_, err = client.doRequest(req, &address)
+	// ISO of the currency the deposit is credited in, example: EUR
+	CurrencyTo string `json:"currency_to"`
+
+	// Price of one unit of CurrencyFrom in CurrencyTo, example: "8905.40000000"
+	Rate string `json:"rate"`
+
+	// ExpiresAt is when the rate stops being offered
+	ExpiresAt Time `json:"expires_at"`
+}
+
+// GetFuturesRates Returns the guaranteed rates currently offered for future deposits
+func (client *Client) GetFuturesRates() ([]FuturesRate, error) {
+
+	req, err := client.newRequest("futures/rates", []byte("{}"))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &address, nil
-}
+	var response struct {
+		Data []FuturesRate `json:"data"`
+	}
 
-type ID string
-func (id *ID) UnmarshalJSON(data []byte) error {
-	*id = ID(data)
-	return nil
+	_, err = client.doRequest(req, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Data, nil
 }
 
-// WithdrawCryptoInput specifies the parameters the WithdrawCrypto method accepts.
-type WithdrawCryptoInput struct {
+// PrepareFuturesTransactionInput specifies the parameters the PrepareFuturesTransaction method accepts.
+type PrepareFuturesTransactionInput struct {
 	// Unique foreign ID in your system, example: "122929"
 	ForeignID string `json:"foreign_id"`
 
-	// Amount of funds to withdraw, example: "3500"
-	Amount float64 `json:"amount"`
+	// ISO of the currency that will be deposited, example: BTC
+	CurrencyFrom string `json:"currency_from"`
 
-	// ISO of currency to receive funds in, example: BTC
-	Currency string `json:"currency"`
+	// ISO of the currency the deposit is credited in, example: EUR
+	CurrencyTo string `json:"currency_to"`
 
-	// Cryptocurrency address where you want to send funds.
-	Address string `json:"address"`
+	// Amount of CurrencyFrom expected in whole units, example: 0.01
+	Amount Amount `json:"amount"`
+}
 
-	// Tag (if it's Ripple or BNB) or memo (if it's Bitshares or EOS)
-	Tag string `json:"tag"`
+// FuturesQuote holds a rate locked for a future deposit, returned from the API
+type FuturesQuote struct {
+	// ID of the quote, pass it to ConfirmFuturesTransaction
+	ID           QuoteID `json:"id"`
+	ForeignID    string  `json:"foreign_id"`
+	CurrencyFrom string  `json:"currency_from"`
+	CurrencyTo   string  `json:"currency_to"`
+	Amount       string  `json:"amount"`
+	Rate         string  `json:"rate"`
+
+	// ExpiresAt is the deadline for confirming the quote
+	ExpiresAt Time `json:"expires_at"`
 }
 
 // UnmarshalJSON parses the request from server in the expected format
-func (a *WithdrawCryptoPayload) UnmarshalJSON(data []byte) error {
-	type Alias WithdrawCryptoPayload
+func (a *FuturesQuote) UnmarshalJSON(data []byte) error {
+	type Alias FuturesQuote
 
 	var temp struct {
 		Data Alias `json:"data"`
@@ -216,61 +1526,120 @@ func (a *WithdrawCryptoPayload) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	*a = WithdrawCryptoPayload(temp.Data)
+	*a = FuturesQuote(temp.Data)
 	return nil
 }
 
-// WithdrawCryptoPayload holds the data returned from the API
-type WithdrawCryptoPayload struct {
-	ID        ID    `json:"id"`
-	ForeignID string `json:"foreign_id"`
-	Type string `json:"type"`
-	Status string `json:"status"`
-	Amount string `json:"amount"`
-	SenderCurrency string `json:"sender_currency"`
-	SenderAmount string `json:"sender_amount"`
-	ReceiverCurrency string `json:"receiver_currency"`
-	ReceiverAmount string `json:"receiver_amount"`
-}
+// PrepareFuturesTransaction Locks a rate for a future deposit, the quote must then be confirmed with
+// ConfirmFuturesTransaction before it expires
+func (client *Client) PrepareFuturesTransaction(input *PrepareFuturesTransactionInput) (*FuturesQuote, error) {
 
-// WithdrawCrypto Withdraw crypto to any specified address.
-func (client *Client) WithdrawCrypto(input *WithdrawCryptoInput) (*WithdrawCryptoPayload, error) {
+	if input == nil {
+		return nil, ErrInputRequired
+	}
 
-	relativeURL := &url.URL{Path: "withdrawal/crypto"}
-	url := client.BaseURL.ResolveReference(relativeURL)
+	if input.Amount <= 0 {
+		return nil, errors.New("amount must be greater than zero")
+	}
 
-	j, err := json.Marshal(input)
+	normalized := *input
+	normalized.CurrencyFrom = client.currencyCode(input.CurrencyFrom)
+	normalized.CurrencyTo = client.currencyCode(input.CurrencyTo)
+
+	j, err := json.Marshal(&normalized)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(j))
+	req, err := client.newRequest("futures/prepare", j)
 
 	if err != nil {
 		return nil, err
 	}
 
-	signedBody, err := client.createSignedRequestHeader(j)
+	var futuresQuote FuturesQuote
+
+	_, err = client.doRequest(req, &futuresQuote)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Processing-Key", client.apiKey)
-	req.Header.Set("X-Processing-Signature", signedBody)
+	return &futuresQuote, nil
+}
 
-	var withdrawCryptoPayload WithdrawCryptoPayload
+// ConfirmFuturesTransactionInput specifies the parameters the ConfirmFuturesTransaction method accepts.
+type ConfirmFuturesTransactionInput struct {
+	// ID of the quote returned by PrepareFuturesTransaction
+	ID QuoteID `json:"id"`
+}
 
-	_, err = client.doRequest(req, &withdrawCryptoPayload)
+// FuturesTransaction holds a confirmed futures transaction returned from the API
+type FuturesTransaction struct {
+	ID           ID     `json:"id"`
+	ForeignID    string `json:"foreign_id"`
+	Status       string `json:"status"`
+	CurrencyFrom string `json:"currency_from"`
+	CurrencyTo   string `json:"currency_to"`
+	Amount       string `json:"amount"`
+	Rate         string `json:"rate"`
+
+	// Address (and Tag for currencies that need one) to deposit CurrencyFrom to at the locked rate
+	Address string `json:"address"`
+	Tag     string `json:"tag"`
+}
+
+// UnmarshalJSON parses the request from server in the expected format
+func (a *FuturesTransaction) UnmarshalJSON(data []byte) error {
+	type Alias FuturesTransaction
+
+	var temp struct {
+		Data Alias `json:"data"`
+	}
+
+	err := json.Unmarshal(data, &temp)
+
+	if err != nil {
+		return err
+	}
+
+	*a = FuturesTransaction(temp.Data)
+	return nil
+}
+
+// ConfirmFuturesTransaction Confirms a quote returned by PrepareFuturesTransaction, completing the futures flow
+func (client *Client) ConfirmFuturesTransaction(input *ConfirmFuturesTransactionInput) (*FuturesTransaction, error) {
+
+	if input == nil {
+		return nil, ErrInputRequired
+	}
+
+	if input.ID.Value == "" {
+		return nil, errors.New("id of the prepared quote is required")
+	}
+
+	j, err := json.Marshal(input)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &withdrawCryptoPayload, nil
+	req, err := client.newRequest("futures/confirm", j)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var futuresTransaction FuturesTransaction
+
+	_, err = client.doRequest(req, &futuresTransaction)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &futuresTransaction, nil
 }
 
 func checkResponse(r *http.Response) error {
@@ -293,17 +1662,161 @@ func checkResponse(r *http.Response) error {
 		}
 	}
 
+	if r.StatusCode == http.StatusServiceUnavailable && isMaintenance(errorResponse) {
+		return &MaintenanceError{
+			Response:   r,
+			Message:    errorResponse.Message,
+			RetryAfter: parseRetryAfter(r.Header.Get("Retry-After")),
+		}
+	}
+
 	if r.StatusCode == http.StatusBadRequest {
 		validationErrorResponse := &ValidationErrorResponse{Response: r}
 		err = json.Unmarshal(body, validationErrorResponse)
-		return validationErrorResponse
+
+		// Some 400s carry only an error/code pair, fall back to ErrorResponse for those
+		if err == nil && len(validationErrorResponse.Errors) > 0 {
+			return validationErrorResponse
+		}
 	}
 
 	return errorResponse
 }
 
-func (client *Client) createSignedRequestHeader(body []byte) (response string, err error) {
-	h := hmac.New(sha512.New, []byte(client.apiSecret))
+// ErrorClass groups errors returned by the client by how callers should react to them
+type ErrorClass int
+
+const (
+	// Fatal errors won't succeed on retry, e.g. a missing resource or a local input error
+	Fatal ErrorClass = iota
+
+	// Retryable errors are transient: transport failures, 5xx responses and maintenance
+	Retryable
+
+	// Auth errors mean the credentials were rejected (401/403)
+	Auth
+
+	// RateLimited errors mean the request quota was exhausted (429)
+	RateLimited
+
+	// Validation errors mean the API rejected the submitted input (400/422)
+	Validation
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Retryable:
+		return "retryable"
+	case Auth:
+		return "auth"
+	case RateLimited:
+		return "rate_limited"
+	case Validation:
+		return "validation"
+	default:
+		return "fatal"
+	}
+}
+
+// Classify returns the ErrorClass of an error returned by the client. A nil error is
+// reported as Fatal, there is nothing to retry.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return Fatal
+	}
+
+	var maintenanceError *MaintenanceError
+	var validationErrorResponse *ValidationErrorResponse
+	var errorResponse *ErrorResponse
+	var urlError *url.Error
+
+	switch {
+	case errors.As(err, &maintenanceError):
+		return Retryable
+	case errors.As(err, &validationErrorResponse):
+		return Validation
+	case errors.As(err, &errorResponse):
+		return classifyStatus(errorResponse.Response.StatusCode)
+	case errors.As(err, &urlError):
+		return classifyTransportError(urlError)
+	}
+
+	return Fatal
+}
+
+// classifyTransportError retries failures that may clear up, timeouts and network errors, but not
+// certificate or malformed request errors that fail the same way on every attempt
+func classifyTransportError(urlError *url.Error) ErrorClass {
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var certificateInvalidError x509.CertificateInvalidError
+	var hostnameError x509.HostnameError
+	var opError *net.OpError
+
+	switch {
+	case errors.As(urlError, &unknownAuthorityError),
+		errors.As(urlError, &certificateInvalidError),
+		errors.As(urlError, &hostnameError):
+		return Fatal
+	case urlError.Timeout(), urlError.Temporary():
+		return Retryable
+	case errors.As(urlError, &opError):
+		return Retryable
+	case errors.Is(urlError, io.EOF), errors.Is(urlError, io.ErrUnexpectedEOF):
+		return Retryable
+	}
+
+	return Fatal
+}
+
+func classifyStatus(status int) ErrorClass {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return Auth
+	case status == http.StatusTooManyRequests:
+		return RateLimited
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return Validation
+	case status >= 500:
+		return Retryable
+	}
+
+	return Fatal
+}
+
+// isMaintenance tells a planned maintenance window apart from an outage by the markers
+// the API puts in the body. Retry-After alone isn't enough, load balancers send it on outages too.
+func isMaintenance(errorResponse *ErrorResponse) bool {
+	if errorResponse.Code == "maintenance" {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(errorResponse.Message), "maintenance")
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header, delay seconds and an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// createSignedRequestHeader computes the X-Processing-Signature value. Coinspaid signs the raw
+// request body only, as a hex encoded HMAC-SHA512 keyed with the API secret, the method and path
+// are not part of the signed input.
+func createSignedRequestHeader(apiSecret string, body []byte) (response string, err error) {
+	h := hmac.New(sha512.New, []byte(apiSecret))
 
 	h.Write([]byte(body))
 
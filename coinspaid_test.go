@@ -1,9 +1,19 @@
 package coinspaid
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
+	"time"
 
 	"testing"
 
@@ -33,6 +43,16 @@ const (
 		}
 	}`
 
+	badRequestWithoutErrorsResponse = `{
+		"error": "Currency is not supported",
+		"code": "unsupported_currency"
+	}`
+
+	maintenanceResponse = `{
+		"error": "Scheduled maintenance in progress",
+		"code": "maintenance"
+	}`
+
 	withdrawCryptoOkResponse = `{
 		"data": {
 			"id": 1,
@@ -46,6 +66,137 @@ const (
 			"receiver_currency": "ETH"
 		}
 	}`
+
+	balancesOkResponse = `{
+		"data": [
+			{
+				"currency": "BTC",
+				"type": "crypto",
+				"balance": "0.05000000"
+			},
+			{
+				"currency": "EUR",
+				"type": "fiat",
+				"balance": "1200.50000000"
+			}
+		]
+	}`
+
+	currenciesOkResponse = `{
+		"data": [
+			{
+				"id": 1,
+				"type": "crypto",
+				"currency": "BTC",
+				"minimum_amount": "0.00020000",
+				"deposit_fee_percent": "0.008000",
+				"withdrawal_fee": "0.00040000",
+				"precision": 8
+			},
+			{
+				"id": 20,
+				"type": "fiat",
+				"currency": "EUR",
+				"minimum_amount": "10.00000000",
+				"deposit_fee_percent": "0.000000",
+				"withdrawal_fee": "0.00000000",
+				"precision": 2
+			}
+		]
+	}`
+
+	currencyPairsOkResponse = `{
+		"data": [
+			{
+				"currency_from": {
+					"currency": "BTC",
+					"type": "crypto",
+					"min_amount": "0.00100000",
+					"min_amount_deposit_with_exchange": "0.00001000"
+				},
+				"currency_to": {
+					"currency": "EUR",
+					"type": "fiat"
+				},
+				"rate_from": "1",
+				"rate_to": "8905.40000000"
+			}
+		]
+	}`
+
+	calculateExchangeOkResponse = `{
+		"data": {
+			"sender_amount": "0.01000000",
+			"sender_currency": "BTC",
+			"receiver_amount": "89.05400000",
+			"receiver_currency": "EUR",
+			"fee_amount": "0.00010000",
+			"fee_currency": "BTC",
+			"price": "8905.40000000",
+			"ts_fixed": 1589452200,
+			"ts_release": 1589452260,
+			"fix_period": 60
+		}
+	}`
+
+	exchangeOkResponse = `{
+		"data": {
+			"id": 42,
+			"foreign_id": "exchange-id:17",
+			"type": "exchange",
+			"status": "processing",
+			"sender_amount": "0.01000000",
+			"sender_currency": "BTC",
+			"receiver_amount": "89.05400000",
+			"receiver_currency": "EUR",
+			"fee_amount": "0.00010000",
+			"fee_currency": "BTC",
+			"price": "8905.40000000"
+		}
+	}`
+
+	futuresRatesOkResponse = `{
+		"data": [
+			{
+				"currency_from": "BTC",
+				"currency_to": "EUR",
+				"rate": "8905.40000000",
+				"expires_at": 1589455800
+			},
+			{
+				"currency_from": "ETH",
+				"currency_to": "EUR",
+				"rate": "185.12000000",
+				"expires_at": 1589455800
+			}
+		]
+	}`
+
+	futuresPrepareOkResponse = `{
+		"data": {
+			"id": "fq-7731",
+			"foreign_id": "user-id:2048",
+			"currency_from": "BTC",
+			"currency_to": "EUR",
+			"amount": "0.01000000",
+			"rate": "8905.40000000",
+			"expires_at": 1589453100
+		}
+	}`
+
+	futuresConfirmOkResponse = `{
+		"data": {
+			"id": "fq-7731",
+			"foreign_id": "user-id:2048",
+			"status": "confirmed",
+			"currency_from": "BTC",
+			"currency_to": "EUR",
+			"amount": "0.01000000",
+			"rate": "8905.40000000",
+			"address": "12983h13ro1hrt24it432t",
+			"tag": ""
+		}
+	}`
 )
 
 func TestTakeAddress(t *testing.T) {
@@ -93,9 +244,9 @@ func TestWithdrawCrypto(t *testing.T) {
 
 	withdrawCryptoInput := &WithdrawCryptoInput{
 		ForeignID: "user-id:2048",
-		Amount:  200000000,
+		Amount:    0.01,
 		Currency:  "BTC",
-		Address:  "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
+		Address:   "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
 	}
 
 	response, err := api.WithdrawCrypto(withdrawCryptoInput)
@@ -103,6 +254,16 @@ func TestWithdrawCrypto(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, withdrawCryptoInput.ForeignID, response.ForeignID)
 	assert.Equal(t, string(response.ID), "1")
+	assert.Equal(t, "", response.Network)
+}
+
+func TestWithdrawCryptoPayloadNetwork(t *testing.T) {
+	var payload WithdrawCryptoPayload
+
+	err := json.Unmarshal([]byte(`{"data": {"id": 1, "sender_currency": "USDT", "network": "ERC20"}}`), &payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ERC20", payload.Network)
 }
 
 func TestClientWithInvalidAuth(t *testing.T) {
@@ -131,6 +292,36 @@ func TestClientWithInvalidAuth(t *testing.T) {
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "bad_header_key", err.(*ErrorResponse).Code)
+	assert.True(t, errors.Is(err, ErrForbidden))
+	assert.True(t, errors.Is(err, ErrAuth))
+	assert.False(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestClientWithUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		rw.Write([]byte(invalidAuthResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "invalid",
+		apiSecret:  "invalid",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.True(t, errors.Is(err, ErrAuth))
+	assert.False(t, errors.Is(err, ErrForbidden))
 }
 
 func TestClientWithBadRequest(t *testing.T) {
@@ -158,4 +349,1518 @@ func TestClientWithBadRequest(t *testing.T) {
 
 	assert.NotNil(t, err)
 	assert.NotNil(t, err.(*ValidationErrorResponse).Errors)
+	assert.False(t, errors.Is(err, ErrTagRequired))
+}
+
+func TestClientWithBadRequestWithoutErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(badRequestWithoutErrorsResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	takeAddressInput := &TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "INEXISTENT",
+	}
+
+	_, err := api.TakeAddress(takeAddressInput)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "unsupported_currency", err.(*ErrorResponse).Code)
+	assert.Equal(t, "Currency is not supported", err.(*ErrorResponse).Message)
+}
+
+func TestSetCredentials(t *testing.T) {
+	var receivedKey, receivedSignature, expectedSignature string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		h := hmac.New(sha512.New, []byte("rotated-secret"))
+		h.Write(body)
+
+		receivedKey = req.Header.Get("X-Processing-Key")
+		receivedSignature = req.Header.Get("X-Processing-Signature")
+		expectedSignature = hex.EncodeToString(h.Sum(nil))
+
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	err := api.SetCredentials("rotated-key", "rotated-secret")
+
+	assert.Nil(t, err)
+
+	_, err = api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated-key", receivedKey)
+	assert.Equal(t, expectedSignature, receivedSignature)
+	assert.NotNil(t, api.SetCredentials("", ""))
+}
+
+func TestSetCredentialsConcurrently(t *testing.T) {
+	secrets := map[string]string{
+		"key-a": "secret-a",
+		"key-b": "secret-b",
+	}
+
+	var mu sync.Mutex
+	var mismatches int
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		expectedSignature, _ := createSignedRequestHeader(secrets[req.Header.Get("X-Processing-Key")], body)
+
+		if req.Header.Get("X-Processing-Signature") != expectedSignature {
+			mu.Lock()
+			mismatches++
+			mu.Unlock()
+		}
+
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key-a",
+		apiSecret:  "secret-a",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if i%10 == 0 {
+				if i%20 == 0 {
+					errs <- api.SetCredentials("key-b", "secret-b")
+				} else {
+					errs <- api.SetCredentials("key-a", "secret-a")
+				}
+
+				return
+			}
+
+			_, err := api.TakeAddress(&TakeAddressInput{
+				ForeignID: "user-id:2048",
+				Currency:  "EUR",
+			})
+
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 0, mismatches)
+}
+
+func TestNewClientRequiresHTTPS(t *testing.T) {
+	_, err := NewClient("key", "secret", APIBaseLiveURL)
+	assert.Nil(t, err)
+
+	_, err = NewClient("key", "secret", "http://app.coinspaid.com/api/v2/")
+	assert.NotNil(t, err)
+
+	_, err = NewClient("key", "secret", "http://127.0.0.1:8080/api/v2/")
+	assert.Nil(t, err)
+
+	_, err = NewClient("key", "secret", "http://localhost:8080/api/v2/")
+	assert.Nil(t, err)
+
+	_, err = NewClient("key", "secret", "http://mock.internal/api/v2/", WithInsecureBaseURL())
+	assert.Nil(t, err)
+}
+
+func TestMakeForeignID(t *testing.T) {
+	assert.Equal(t, "user-id:2048", MakeForeignID("user-id", "2048"))
+	assert.Equal(t, "tenant:user:2048", MakeForeignID("tenant", "user", "2048"))
+}
+
+func TestCreateSignedRequestHeader(t *testing.T) {
+	vectors := []struct {
+		secret    string
+		body      string
+		signature string
+	}{
+		{
+			secret:    "AbCdEfG123456",
+			body:      `{"foreign_id":"user-id:2048","currency":"BTC"}`,
+			signature: "60f5ce7d7b1275a461243c4ab12846f2971317042015c92cca183056bd0c5e0e078a2b983de544f068ae4f984f73e741eb76647c1d4a450fecbf26ef34801c60",
+		},
+		{
+			secret:    "secret",
+			body:      "",
+			signature: "b0e9650c5faf9cd8ae02276671545424104589b3656731ec193b25d01b07561c27637c2d4d68389d6cf5007a8632c26ec89ba80a01c77a6cdd389ec28db43901",
+		},
+	}
+
+	for _, vector := range vectors {
+		signature, err := createSignedRequestHeader(vector.secret, []byte(vector.body))
+
+		assert.Nil(t, err)
+		assert.Equal(t, vector.signature, signature)
+	}
+}
+
+func TestClientDuringMaintenance(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "120")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte(maintenanceResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.True(t, errors.Is(err, ErrMaintenance))
+	assert.Equal(t, 120*time.Second, err.(*MaintenanceError).RetryAfter)
+	assert.Equal(t, "Scheduled maintenance in progress", err.(*MaintenanceError).Message)
+}
+
+func TestClientWithServiceUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("upstream connect error"))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.False(t, errors.Is(err, ErrMaintenance))
+	assert.Equal(t, "upstream connect error", err.(*ErrorResponse).Message)
+}
+
+func TestClientWithServiceUnavailableAndRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "5")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("<html><body><h1>503 Service Unavailable</h1>upstream down</body></html>"))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.False(t, errors.Is(err, ErrMaintenance))
+	assert.Equal(t, http.StatusServiceUnavailable, err.(*ErrorResponse).Response.StatusCode)
+}
+
+func TestTimeUnmarshalJSON(t *testing.T) {
+	expected := time.Date(2020, time.May, 14, 10, 30, 0, 0, time.UTC)
+
+	inputs := []string{
+		`1589452200`,
+		`"1589452200"`,
+		`"2020-05-14T10:30:00Z"`,
+		`"2020-05-14 10:30:00"`,
+	}
+
+	for _, input := range inputs {
+		var parsed Time
+
+		err := json.Unmarshal([]byte(input), &parsed)
+
+		assert.Nil(t, err, input)
+		assert.True(t, expected.Equal(parsed.Time), input)
+	}
+
+	var parsed Time
+
+	assert.Nil(t, json.Unmarshal([]byte(`null`), &parsed))
+	assert.True(t, parsed.IsZero())
+	assert.NotNil(t, json.Unmarshal([]byte(`"14/05/2020"`), &parsed))
+
+	assert.Nil(t, json.Unmarshal([]byte(`1589452200.5`), &parsed))
+	assert.True(t, expected.Add(500*time.Millisecond).Equal(parsed.Time))
+
+	assert.Nil(t, json.Unmarshal([]byte(`"1589452200.000001"`), &parsed))
+	assert.True(t, expected.Add(time.Microsecond).Equal(parsed.Time))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"1589452200."`), &parsed))
+}
+
+func TestRegisterTimeLayout(t *testing.T) {
+	timeLayoutsMu.Lock()
+	saved := append([]string(nil), timeLayouts...)
+	timeLayoutsMu.Unlock()
+
+	defer func() {
+		timeLayoutsMu.Lock()
+		timeLayouts = saved
+		timeLayoutsMu.Unlock()
+	}()
+
+	var parsed Time
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"2020/05/14 10:30"`), &parsed))
+
+	RegisterTimeLayout("2006/01/02 15:04")
+	RegisterTimeLayout("2006/01/02 15:04")
+
+	assert.Nil(t, json.Unmarshal([]byte(`"2020/05/14 10:30"`), &parsed))
+	assert.True(t, time.Date(2020, time.May, 14, 10, 30, 0, 0, time.UTC).Equal(parsed.Time))
+	assert.Equal(t, len(saved)+1, len(timeLayouts))
+}
+
+func TestSignedBodyBytes(t *testing.T) {
+	var receivedBody []byte
+	var receivedSignature string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		receivedSignature = req.Header.Get("X-Processing-Signature")
+
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	expectedSignature, _ := createSignedRequestHeader("secret", receivedBody)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency":"EUR"}`, string(receivedBody))
+	assert.Equal(t, expectedSignature, receivedSignature)
+}
+
+func TestLastRateLimit(t *testing.T) {
+	withHeaders := true
+	withRemaining := true
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if withHeaders {
+			rw.Header().Set("X-RateLimit-Limit", "60")
+			if withRemaining {
+				rw.Header().Set("X-RateLimit-Remaining", "59")
+			}
+			rw.Header().Set("X-RateLimit-Reset", "1589452200")
+		}
+
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, ok := api.LastRateLimit()
+	assert.False(t, ok)
+
+	takeAddressInput := &TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	}
+
+	_, err := api.TakeAddress(takeAddressInput)
+	assert.Nil(t, err)
+
+	rateLimit, ok := api.LastRateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, 60, rateLimit.Limit)
+	assert.Equal(t, 59, rateLimit.Remaining)
+	assert.Equal(t, int64(1589452200), rateLimit.Reset.Unix())
+
+	withHeaders = false
+
+	_, err = api.TakeAddress(takeAddressInput)
+	assert.Nil(t, err)
+
+	rateLimit, ok = api.LastRateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, 59, rateLimit.Remaining)
+
+	withHeaders = true
+	withRemaining = false
+
+	_, err = api.TakeAddress(takeAddressInput)
+	assert.Nil(t, err)
+
+	rateLimit, ok = api.LastRateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, 59, rateLimit.Remaining)
+}
+
+func TestWithdrawCryptoRejectsNonPositiveAmount(t *testing.T) {
+	api := Client{
+		apiKey:    "key",
+		apiSecret: "secret",
+	}
+
+	_, err := api.WithdrawCrypto(&WithdrawCryptoInput{
+		ForeignID: "user-id:2048",
+		Currency:  "BTC",
+		Address:   "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestClassify(t *testing.T) {
+	responses := []struct {
+		status int
+		body   string
+		class  ErrorClass
+	}{
+		{http.StatusForbidden, invalidAuthResponse, Auth},
+		{http.StatusUnauthorized, invalidAuthResponse, Auth},
+		{http.StatusTooManyRequests, `{"error": "Too many requests"}`, RateLimited},
+		{http.StatusBadRequest, badRequestResponse, Validation},
+		{http.StatusBadRequest, badRequestWithoutErrorsResponse, Validation},
+		{http.StatusNotFound, `{"error": "Not found"}`, Fatal},
+		{http.StatusInternalServerError, `{"error": "Server error"}`, Retryable},
+		{http.StatusServiceUnavailable, maintenanceResponse, Retryable},
+	}
+
+	for _, response := range responses {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(response.status)
+			rw.Write([]byte(response.body))
+		}))
+
+		baseURL, _ := url.Parse(server.URL)
+
+		api := Client{
+			apiKey:     "key",
+			apiSecret:  "secret",
+			httpClient: server.Client(),
+			BaseURL:    baseURL,
+		}
+
+		_, err := api.TakeAddress(&TakeAddressInput{
+			ForeignID: "user-id:2048",
+			Currency:  "EUR",
+		})
+
+		server.Close()
+
+		assert.Equal(t, response.class, Classify(err), response.body)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	baseURL, _ := url.Parse(server.URL)
+	server.Close()
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.Equal(t, Retryable, Classify(err))
+	assert.Equal(t, Fatal, Classify(errors.New("amount must be greater than zero")))
+	assert.Equal(t, Fatal, Classify(nil))
+}
+
+func TestClassifyTransportErrors(t *testing.T) {
+	takeAddressInput := &TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	}
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer tlsServer.Close()
+
+	baseURL, _ := url.Parse(tlsServer.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: &http.Client{},
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.TakeAddress(takeAddressInput)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, Fatal, Classify(err), "untrusted certificate")
+
+	api.BaseURL, _ = url.Parse("ftp://app.coinspaid.com/api/v2/")
+
+	_, err = api.TakeAddress(takeAddressInput)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, Fatal, Classify(err), "unsupported protocol scheme")
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer slowServer.Close()
+
+	api.BaseURL, _ = url.Parse(slowServer.URL)
+	api.httpClient = &http.Client{Timeout: 20 * time.Millisecond}
+
+	_, err = api.TakeAddress(takeAddressInput)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, Retryable, Classify(err), "timeout")
+}
+
+func TestNewClientConnectionPool(t *testing.T) {
+	client, err := NewClient("key", "secret", APIBaseLiveURL, WithMaxIdleConnsPerHost(32), WithMaxConnsPerHost(64))
+
+	assert.Nil(t, err)
+
+	transport := client.httpClient.Transport.(*http.Transport)
+
+	assert.Equal(t, 32, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 64, transport.MaxConnsPerHost)
+
+	httpClient := &http.Client{}
+	client, err = NewClient("key", "secret", APIBaseLiveURL, WithHTTPClient(httpClient), WithMaxIdleConnsPerHost(32))
+
+	assert.Nil(t, err)
+	assert.Equal(t, httpClient, client.httpClient)
+	assert.Nil(t, client.httpClient.Transport)
+}
+
+type wrappedTransport struct {
+	http.RoundTripper
+	calls *int
+}
+
+func (transport wrappedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*transport.calls++
+	return transport.RoundTripper.RoundTrip(req)
+}
+
+func TestNewClientWithWrappedDefaultTransport(t *testing.T) {
+	calls := 0
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = wrappedTransport{defaultTransport, &calls}
+
+	defer func() {
+		http.DefaultTransport = defaultTransport
+	}()
+
+	client, err := NewClient("key", "secret", APIBaseLiveURL)
+
+	assert.Nil(t, err)
+	assert.Nil(t, client.httpClient.Transport)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	client, err = NewClient("key", "secret", server.URL+"/", WithMaxIdleConnsPerHost(32), WithMaxConnsPerHost(64))
+
+	assert.Nil(t, err)
+	assert.Nil(t, client.httpClient.Transport)
+
+	_, err = client.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestWithdrawCryptoWithoutTag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"errors": {"tag": "The tag field is required."}}`))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, err := api.WithdrawCrypto(&WithdrawCryptoInput{
+		ForeignID: "user-id:2048",
+		Amount:    25,
+		Currency:  "XRP",
+		Address:   "rEb8TK3gBgk5auZkwc6sHnwrGVJH8DuaLh",
+	})
+
+	assert.True(t, errors.Is(err, ErrTagRequired))
+	assert.NotNil(t, err.(*ValidationErrorResponse).Errors["tag"])
+}
+
+func TestTakeAddressEffectiveConvertTo(t *testing.T) {
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+
+		rw.Write([]byte(`{
+			"data": {
+				"id": 1,
+				"currency": "BTC",
+				"convert_to": "EUR",
+				"address": "12983h13ro1hrt24it432t",
+				"foreign_id": "user-id:2048"
+			}
+		}`))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	address, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "BTC",
+		ConvertTo: "USD",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency":"BTC","convert_to":"USD"}`, string(receivedBody))
+	assert.Equal(t, "EUR", address.ConvertTo)
+}
+
+func TestIDUnmarshalJSON(t *testing.T) {
+	var payload WithdrawCryptoPayload
+
+	err := json.Unmarshal([]byte(`{"data": {"id": 12345678901234567890123}}`), &payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ID("12345678901234567890123"), payload.ID)
+
+	err = json.Unmarshal([]byte(`{"data": {"id": "98765432109876543210"}}`), &payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ID("98765432109876543210"), payload.ID)
+
+	payload = WithdrawCryptoPayload{}
+	err = json.Unmarshal([]byte(`{"data": {"id": null}}`), &payload)
+
+	assert.Nil(t, err)
+	assert.Equal(t, ID(""), payload.ID)
+}
+
+func TestIDMarshalJSON(t *testing.T) {
+	for _, id := range []ID{"007", "123", "fq-7731"} {
+		encoded, err := json.Marshal(id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, `"`+string(id)+`"`, string(encoded))
+	}
+}
+
+func TestQuoteIDJSON(t *testing.T) {
+	for _, raw := range []string{`"007"`, `"123"`, `"fq-7731"`, `7731`, `12345678901234567890123`} {
+		var id QuoteID
+
+		assert.Nil(t, json.Unmarshal([]byte(raw), &id))
+
+		encoded, err := json.Marshal(ConfirmFuturesTransactionInput{ID: id})
+
+		assert.Nil(t, err)
+		assert.Equal(t, `{"id":`+raw+`}`, string(encoded))
+	}
+
+	var id QuoteID
+
+	assert.Nil(t, json.Unmarshal([]byte(`"123"`), &id))
+	assert.Equal(t, QuoteID{Value: "123"}, id)
+
+	id = QuoteID{}
+	assert.Nil(t, json.Unmarshal([]byte(`null`), &id))
+	assert.Equal(t, QuoteID{}, id)
+
+	_, err := json.Marshal(QuoteID{Value: "007", Numeric: true})
+	assert.NotNil(t, err)
+}
+
+func TestSigningObserver(t *testing.T) {
+	var observedPath string
+	var observedBody, receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	api, err := NewClient("key", "secret", server.URL+"/api/v2/", WithSigningObserver(func(path string, body []byte) {
+		observedPath = path
+		observedBody = append([]byte(nil), body...)
+	}))
+
+	assert.Nil(t, err)
+
+	_, err = api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/api/v2/addresses/take", observedPath)
+	assert.Equal(t, receivedBody, observedBody)
+}
+
+func TestBuildWithdrawCryptoRequest(t *testing.T) {
+	baseURL, _ := url.Parse(APISBaseSandboxURL)
+
+	api := Client{
+		apiKey:    "key",
+		apiSecret: "secret",
+		BaseURL:   baseURL,
+	}
+
+	req, body, err := api.BuildWithdrawCryptoRequest(&WithdrawCryptoInput{
+		ForeignID: "user-id:2048",
+		Amount:    0.01,
+		Currency:  "BTC",
+		Address:   "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
+	})
+
+	assert.Nil(t, err)
+
+	sentBody, _ := ioutil.ReadAll(req.Body)
+	expectedSignature, _ := createSignedRequestHeader("secret", body)
+
+	assert.Equal(t, body, sentBody)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, APISBaseSandboxURL+"withdrawal/crypto", req.URL.String())
+	assert.Equal(t, "key", req.Header.Get("X-Processing-Key"))
+	assert.Equal(t, expectedSignature, req.Header.Get("X-Processing-Signature"))
+}
+
+func TestAmountMarshalJSON(t *testing.T) {
+	for amount, expected := range map[Amount]string{
+		0.0000005: "0.0000005",
+		0.01:      "0.01",
+		100:       "100",
+		123456789: "123456789",
+		1e21:      "1000000000000000000000",
+	} {
+		encoded, err := json.Marshal(amount)
+
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(encoded))
+	}
+
+	_, err := json.Marshal(Amount(math.NaN()))
+	assert.NotNil(t, err)
+}
+
+func TestWithdrawCryptoSmallAmount(t *testing.T) {
+	baseURL, _ := url.Parse(APISBaseSandboxURL)
+
+	api := Client{
+		apiKey:    "key",
+		apiSecret: "secret",
+		BaseURL:   baseURL,
+	}
+
+	_, body, err := api.BuildWithdrawCryptoRequest(&WithdrawCryptoInput{
+		ForeignID: "user-id:2048",
+		Amount:    0.0000005,
+		Currency:  "BTC",
+		Address:   "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `"amount":0.0000005,`)
+}
+
+func TestCurrencyCodeNormalization(t *testing.T) {
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	takeAddressInput := &TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  " btc",
+		ConvertTo: "eur",
+	}
+
+	api, _ := NewClient("key", "secret", server.URL)
+
+	_, err := api.TakeAddress(takeAddressInput)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency":"BTC","convert_to":"EUR"}`, string(receivedBody))
+	assert.Equal(t, " btc", takeAddressInput.Currency)
+
+	strictAPI, _ := NewClient("key", "secret", server.URL, WithStrictCurrencyCodes())
+
+	_, err = strictAPI.TakeAddress(takeAddressInput)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency":" btc","convert_to":"eur"}`, string(receivedBody))
+
+	_, body, err := api.BuildWithdrawCryptoRequest(&WithdrawCryptoInput{
+		ForeignID: "user-id:2048",
+		Amount:    0.01,
+		Currency:  "btc",
+		Address:   "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt",
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `"currency":"BTC"`)
+}
+
+func TestCaptureOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(badRequestResponse))
+	}))
+
+	defer server.Close()
+
+	takeAddressInput := &TakeAddressInput{
+		Currency: "EUR",
+	}
+
+	api, _ := NewClient("key", "secret", server.URL, WithCaptureOnError())
+
+	_, err := api.TakeAddress(takeAddressInput)
+
+	diagnostic := err.(*ValidationErrorResponse).Diagnostic
+
+	assert.NotNil(t, diagnostic)
+	assert.Equal(t, "POST", diagnostic.Method)
+	assert.Equal(t, server.URL+"/addresses/take", diagnostic.URL)
+	assert.Equal(t, "[REDACTED]", diagnostic.RequestHeader.Get("X-Processing-Key"))
+	assert.Equal(t, "[REDACTED]", diagnostic.RequestHeader.Get("X-Processing-Signature"))
+	assert.Equal(t, `{"foreign_id":"","currency":"EUR"}`, string(diagnostic.RequestBody))
+	assert.Equal(t, http.StatusBadRequest, diagnostic.StatusCode)
+	assert.Equal(t, badRequestResponse, string(diagnostic.ResponseBody))
+	assert.Equal(t, "The foreign id field is required.", err.(*ValidationErrorResponse).Errors["foreign_id"])
+
+	api, _ = NewClient("key", "secret", server.URL)
+
+	_, err = api.TakeAddress(takeAddressInput)
+
+	assert.Nil(t, err.(*ValidationErrorResponse).Diagnostic)
+}
+
+func TestWithdrawalAllowlist(t *testing.T) {
+	api, _ := NewClient("key", "secret", APIBaseLiveURL, WithWithdrawalAllowlist(map[string][]string{
+		"btc": {"3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt"},
+		"ETH": {"0x52908400098527886E0F7030069857D2E4169EE7"},
+	}))
+
+	withdrawals := []struct {
+		currency string
+		address  string
+		allowed  bool
+	}{
+		{"BTC", "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt", true},
+		{"BTC", "3p3qsmvk89jbnqzqv5zmakg8fk3kjm4rjt", false},
+		{"BTC", "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", false},
+		{"ETH", "0x52908400098527886e0f7030069857d2e4169ee7", true},
+		{"LTC", "3P3QsMVK89JBNqZQv5zMAKG8FK3kJM4rjt", false},
+	}
+
+	for _, withdrawal := range withdrawals {
+		_, _, err := api.BuildWithdrawCryptoRequest(&WithdrawCryptoInput{
+			ForeignID: "user-id:2048",
+			Amount:    0.01,
+			Currency:  withdrawal.currency,
+			Address:   withdrawal.address,
+		})
+
+		if withdrawal.allowed {
+			assert.Nil(t, err, withdrawal.address)
+		} else {
+			assert.True(t, errors.Is(err, ErrAddressNotAllowed), withdrawal.address)
+		}
+	}
+}
+
+func TestSelfTestSignature(t *testing.T) {
+	api := Client{
+		apiKey:    "key",
+		apiSecret: "secret",
+	}
+
+	signature, err := api.SelfTestSignature()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ade7e48a3e994b3cdd95b4bb424323d63ad9b7307e829e1779ef520017d3aca7cd6b86d61d56a6cbf472d951d2c4645ac2245d472ec79aefef42bc76653ae538", signature)
+
+	api.apiSecret = "secret\n"
+
+	_, err = api.SelfTestSignature()
+
+	assert.NotNil(t, err)
+}
+
+func TestLastServerTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Date", "Thu, 14 May 2020 10:30:00 GMT")
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	_, ok := api.LastServerTime()
+	assert.False(t, ok)
+
+	_, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	assert.Nil(t, err)
+
+	serverTime, ok := api.LastServerTime()
+
+	assert.True(t, ok)
+	assert.True(t, time.Date(2020, time.May, 14, 10, 30, 0, 0, time.UTC).Equal(serverTime))
+}
+
+func TestWireDump(t *testing.T) {
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(okResponse))
+	}))
+
+	defer server.Close()
+
+	var dump bytes.Buffer
+
+	api, _ := NewClient("dump-key", "secret", server.URL, WithWireDump(&dump))
+
+	address, err := api.TakeAddress(&TakeAddressInput{
+		ForeignID: "user-id:2048",
+		Currency:  "EUR",
+	})
+
+	signature, _ := createSignedRequestHeader("secret", receivedBody)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "EUR", address.Currency)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency":"EUR"}`, string(receivedBody))
+	assert.Contains(t, dump.String(), "POST /addresses/take HTTP/1.1")
+	assert.Contains(t, dump.String(), "X-Processing-Key: [REDACTED]")
+	assert.Contains(t, dump.String(), string(receivedBody))
+	assert.Contains(t, dump.String(), "HTTP/1.1 200 OK")
+	assert.Contains(t, dump.String(), "12983h13ro1hrt24it432t")
+	assert.NotContains(t, dump.String(), "dump-key")
+	assert.NotContains(t, dump.String(), signature)
+}
+
+func TestValidationErrorResponseMessages(t *testing.T) {
+	var response ValidationErrorResponse
+
+	err := json.Unmarshal([]byte(`{
+		"errors": {
+			"foreign_id": "The foreign id field is required.",
+			"amount": ["The amount must be a number.", "The amount must be at least 0.0001."]
+		}
+	}`), &response)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "The foreign id field is required.", response.First("foreign_id"))
+	assert.Equal(t, []string{"The foreign id field is required."}, response.All("foreign_id"))
+	assert.Equal(t, "The amount must be a number.", response.Errors["amount"])
+	assert.Equal(t, []string{"The amount must be a number.", "The amount must be at least 0.0001."}, response.All("amount"))
+	assert.Equal(t, "", response.First("currency"))
+	assert.Nil(t, response.All("currency"))
+}
+
+func TestValidationErrorResponseUnexpectedMessage(t *testing.T) {
+	var response ValidationErrorResponse
+
+	err := json.Unmarshal([]byte(`{
+		"errors": {
+			"amount": {"min": "The amount must be at least 0.0001."},
+			"foreign_id": "The foreign id field is required."
+		}
+	}`), &response)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "The foreign id field is required.", response.First("foreign_id"))
+	assert.Equal(t, `{"min": "The amount must be at least 0.0001."}`, response.First("amount"))
+}
+
+func TestGetBalances(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(balancesOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	balances, err := api.GetBalances()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/accounts/list", receivedPath)
+	assert.Equal(t, "{}", string(receivedBody))
+	assert.Len(t, balances, 2)
+	assert.Equal(t, AccountBalance{Currency: "BTC", Type: "crypto", Balance: "0.05000000"}, balances[0])
+	assert.Equal(t, "1200.50000000", balances[1].Balance)
+}
+
+func TestListCurrencies(t *testing.T) {
+	var receivedPath string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		rw.Write([]byte(currenciesOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	currencies, err := api.ListCurrencies()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/currencies/list", receivedPath)
+	assert.Len(t, currencies, 2)
+	assert.Equal(t, Currency{
+		ID:                1,
+		Type:              "crypto",
+		Currency:          "BTC",
+		MinimumAmount:     "0.00020000",
+		DepositFeePercent: "0.008000",
+		WithdrawalFee:     "0.00040000",
+		Precision:         8,
+	}, currencies[0])
+	assert.Equal(t, 2, currencies[1].Precision)
+}
+
+func TestListCurrencyPairs(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(currencyPairsOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	pairs, err := api.ListCurrencyPairs(&CurrencyPairsInput{
+		CurrencyFrom: "BTC",
+		CurrencyTo:   "EUR",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/currencies/pairs", receivedPath)
+	assert.Equal(t, `{"currency_from":"BTC","currency_to":"EUR"}`, string(receivedBody))
+	assert.Len(t, pairs, 1)
+	assert.Equal(t, "BTC", pairs[0].CurrencyFrom.Currency)
+	assert.Equal(t, "0.00100000", pairs[0].CurrencyFrom.MinAmount)
+	assert.Equal(t, "EUR", pairs[0].CurrencyTo.Currency)
+	assert.Equal(t, "8905.40000000", pairs[0].RateTo)
+
+	_, err = api.ListCurrencyPairs(nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(receivedBody))
+}
+
+func TestCalculateExchange(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(calculateExchangeOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	calculation, err := api.CalculateExchange(&CalculateExchangeInput{
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+		SenderAmount:     0.01,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/exchange/calculate", receivedPath)
+	assert.Equal(t, `{"sender_currency":"BTC","receiver_currency":"EUR","sender_amount":0.01}`, string(receivedBody))
+	assert.Equal(t, "89.05400000", calculation.ReceiverAmount)
+	assert.Equal(t, "8905.40000000", calculation.Price)
+	assert.Equal(t, "BTC", calculation.FeeCurrency)
+	assert.Equal(t, int64(1589452260), calculation.TsRelease.Unix())
+	assert.Equal(t, 60, calculation.FixPeriod)
+
+	_, err = api.CalculateExchange(&CalculateExchangeInput{
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+		SenderAmount:     0.0000005,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"sender_currency":"BTC","receiver_currency":"EUR","sender_amount":0.0000005}`, string(receivedBody))
+
+	_, err = api.CalculateExchange(&CalculateExchangeInput{
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+	})
+
+	assert.NotNil(t, err)
+
+	_, err = api.CalculateExchange(&CalculateExchangeInput{
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+		SenderAmount:     0.01,
+		ReceiverAmount:   100,
+	})
+
+	assert.NotNil(t, err)
+}
+
+func TestExchangeFixed(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(exchangeOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	exchangeFixedInput := &ExchangeFixedInput{
+		ForeignID:        "exchange-id:17",
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+		SenderAmount:     0.01,
+		Price:            "8905.40000000",
+	}
+
+	response, err := api.ExchangeFixed(exchangeFixedInput)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/exchange/fixed", receivedPath)
+	assert.Equal(t, `{"foreign_id":"exchange-id:17","sender_currency":"BTC","receiver_currency":"EUR","sender_amount":0.01,"price":"8905.40000000"}`, string(receivedBody))
+	assert.Equal(t, ID("42"), response.ID)
+	assert.Equal(t, exchangeFixedInput.ForeignID, response.ForeignID)
+	assert.Equal(t, "89.05400000", response.ReceiverAmount)
+	assert.Equal(t, "BTC", response.FeeCurrency)
+
+	exchangeFixedInput.SenderAmount = 0.0000005
+
+	_, err = api.ExchangeFixed(exchangeFixedInput)
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(receivedBody), `"sender_amount":0.0000005,`)
+
+	exchangeFixedInput.Price = ""
+
+	_, err = api.ExchangeFixed(exchangeFixedInput)
+
+	assert.NotNil(t, err)
+}
+
+func TestExchangeNow(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(exchangeOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	exchangeNowInput := &ExchangeNowInput{
+		ForeignID:        "exchange-id:17",
+		SenderCurrency:   "BTC",
+		ReceiverCurrency: "EUR",
+		ReceiverAmount:   89.054,
+	}
+
+	response, err := api.ExchangeNow(exchangeNowInput)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/exchange/now", receivedPath)
+	assert.Equal(t, `{"foreign_id":"exchange-id:17","sender_currency":"BTC","receiver_currency":"EUR","receiver_amount":89.054}`, string(receivedBody))
+	assert.Equal(t, ID("42"), response.ID)
+	assert.Equal(t, "processing", response.Status)
+	assert.Equal(t, "0.01000000", response.SenderAmount)
+
+	exchangeNowInput.ReceiverAmount = 0.0000005
+
+	_, err = api.ExchangeNow(exchangeNowInput)
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(receivedBody), `"receiver_amount":0.0000005}`)
+}
+
+func TestGetFuturesRates(t *testing.T) {
+	var receivedPath string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		rw.Write([]byte(futuresRatesOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	rates, err := api.GetFuturesRates()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/futures/rates", receivedPath)
+	assert.Len(t, rates, 2)
+	assert.Equal(t, "BTC", rates[0].CurrencyFrom)
+	assert.Equal(t, "8905.40000000", rates[0].Rate)
+	assert.Equal(t, int64(1589455800), rates[1].ExpiresAt.Unix())
+}
+
+func TestPrepareFuturesTransaction(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(futuresPrepareOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	quote, err := api.PrepareFuturesTransaction(&PrepareFuturesTransactionInput{
+		ForeignID:    "user-id:2048",
+		CurrencyFrom: "BTC",
+		CurrencyTo:   "EUR",
+		Amount:       0.01,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/futures/prepare", receivedPath)
+	assert.Equal(t, `{"foreign_id":"user-id:2048","currency_from":"BTC","currency_to":"EUR","amount":0.01}`, string(receivedBody))
+	assert.Equal(t, QuoteID{Value: "fq-7731"}, quote.ID)
+	assert.Equal(t, "8905.40000000", quote.Rate)
+	assert.Equal(t, int64(1589453100), quote.ExpiresAt.Unix())
+
+	_, err = api.PrepareFuturesTransaction(&PrepareFuturesTransactionInput{
+		ForeignID:    "user-id:2048",
+		CurrencyFrom: "BTC",
+		CurrencyTo:   "EUR",
+		Amount:       0.0000005,
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(receivedBody), `"amount":0.0000005}`)
+}
+
+func TestConfirmFuturesTransaction(t *testing.T) {
+	var receivedPath string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedPath = req.URL.Path
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+		rw.Write([]byte(futuresConfirmOkResponse))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	transaction, err := api.ConfirmFuturesTransaction(&ConfirmFuturesTransactionInput{
+		ID: QuoteID{Value: "fq-7731"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/futures/confirm", receivedPath)
+	assert.Equal(t, `{"id":"fq-7731"}`, string(receivedBody))
+	assert.Equal(t, ID("fq-7731"), transaction.ID)
+	assert.Equal(t, "confirmed", transaction.Status)
+	assert.Equal(t, "12983h13ro1hrt24it432t", transaction.Address)
+
+	_, err = api.ConfirmFuturesTransaction(&ConfirmFuturesTransactionInput{})
+
+	assert.NotNil(t, err)
+}
+
+func TestFuturesTransactionWithNumericID(t *testing.T) {
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		receivedBody, _ = ioutil.ReadAll(req.Body)
+
+		if req.URL.Path == "/futures/prepare" {
+			rw.Write([]byte(`{"data": {"id": 7731, "foreign_id": "user-id:2048", "rate": "8905.40000000"}}`))
+			return
+		}
+
+		rw.Write([]byte(`{"data": {"id": 7731, "foreign_id": "user-id:2048", "status": "confirmed"}}`))
+	}))
+
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+
+	api := Client{
+		apiKey:     "key",
+		apiSecret:  "secret",
+		httpClient: server.Client(),
+		BaseURL:    baseURL,
+	}
+
+	quote, err := api.PrepareFuturesTransaction(&PrepareFuturesTransactionInput{
+		ForeignID:    "user-id:2048",
+		CurrencyFrom: "BTC",
+		CurrencyTo:   "EUR",
+		Amount:       0.01,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, QuoteID{Value: "7731", Numeric: true}, quote.ID)
+
+	transaction, err := api.ConfirmFuturesTransaction(&ConfirmFuturesTransactionInput{
+		ID: quote.ID,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":7731}`, string(receivedBody))
+	assert.Equal(t, "confirmed", transaction.Status)
+}
+
+func TestNilInputs(t *testing.T) {
+	api := Client{
+		apiKey:    "key",
+		apiSecret: "secret",
+	}
+
+	_, err := api.TakeAddress(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.WithdrawCrypto(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, _, err = api.BuildWithdrawCryptoRequest(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.CalculateExchange(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.ExchangeFixed(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.ExchangeNow(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.PrepareFuturesTransaction(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
+
+	_, err = api.ConfirmFuturesTransaction(nil)
+	assert.True(t, errors.Is(err, ErrInputRequired))
 }